@@ -0,0 +1,176 @@
+// Copyright 2023 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// backupMagic identifies the backup stream format, including its version, so
+// Restore can reject streams it does not understand.
+const backupMagic = "nutstore-backup-v1\n"
+
+// restoreBatchSize bounds how many records Restore applies per transaction.
+const restoreBatchSize = 1000
+
+// Backup writes a self-describing streaming snapshot of every (bucket, key,
+// value) triple in the store to w, running inside a single View transaction
+// so the snapshot is consistent even while the database remains open for
+// writes elsewhere. Values are copied exactly as stored, so the snapshot
+// remains valid regardless of any Cipher or KeyHasher configured on s. The
+// result can be loaded into a fresh NutsDB directory with Restore.
+func (s Store) Backup(ctx context.Context, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := io.WriteString(bw, backupMagic); err != nil {
+		return err
+	}
+	err := s.db.View(func(tx *nutsdb.Tx) error {
+		for i := 0; i < s.numBuckets(); i++ {
+			bucket := s.bucket
+			if s.shards > 1 {
+				bucket = s.shardBucket(i)
+			}
+			it := nutsdb.NewIterator(tx, bucket, nutsdb.IteratorOptions{})
+			for {
+				ok, err := it.SetNext()
+				if isNutFound(err) || !ok {
+					break
+				} else if err != nil {
+					return err
+				} else if err := ctx.Err(); err != nil {
+					return err
+				}
+				e := it.Entry()
+				if err := writeRecord(bw, []byte(bucket), e.Key, e.Value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Restore loads a snapshot produced by Backup from r, batching inserts
+// restoreBatchSize records at a time inside Update transactions. Restore
+// does not clear any existing data first; it is meant to populate a fresh
+// NutsDB directory.
+func (s Store) Restore(ctx context.Context, r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("nutstore: read backup header: %w", err)
+	} else if string(magic) != backupMagic {
+		return fmt.Errorf("nutstore: unrecognized backup format %q", magic)
+	}
+
+	type record struct{ bucket, key, value []byte }
+	batch := make([]record, 0, restoreBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := s.db.Update(func(tx *nutsdb.Tx) error {
+			for _, rec := range batch {
+				if err := tx.Put(string(rec.bucket), rec.key, rec.value, nutsdb.Persistent); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bucket, err := readBytes(br)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("nutstore: read backup record: %w", err)
+		}
+		key, err := readBytes(br)
+		if err != nil {
+			return fmt.Errorf("nutstore: read backup record: %w", err)
+		}
+		value, err := readBytes(br)
+		if err != nil {
+			return fmt.Errorf("nutstore: read backup record: %w", err)
+		}
+		batch = append(batch, record{bucket, key, value})
+		if len(batch) >= restoreBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// writeRecord appends a length-prefixed (bucket, key, value) record to w.
+func writeRecord(w io.Writer, bucket, key, value []byte) error {
+	for _, b := range [][]byte{bucket, key, value} {
+		if err := writeBytes(w, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBytes writes b to w preceded by its length as a varint.
+func writeBytes(w io.Writer, b []byte) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(b)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readBytes reads a varint-prefixed byte string from r. It reports io.EOF
+// only when the stream ends cleanly before the length prefix begins; an EOF
+// that interrupts the length prefix or the payload that follows it indicates
+// a truncated stream and is reported as io.ErrUnexpectedEOF instead, so
+// Restore cannot mistake a truncated backup for a clean end of input.
+func readBytes(r io.ByteReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	for i := range buf {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		buf[i] = b
+	}
+	return buf, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutstore
+
+import (
+	"context"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/xujiajun/nutsdb"
+)
+
+// PutBatch writes each of the given blobs to the store inside a single
+// transaction, so that either all of them are recorded or, if any entry
+// fails (for example blob.ErrKeyExists for an entry with Replace false),
+// none of them are. Entries are applied in order, and honor Replace exactly
+// as Put does, including between entries of the batch itself: a later entry
+// with Replace false for a key already written earlier in the same batch
+// fails with blob.ErrKeyExists, even though the earlier write is not yet
+// visible to a Get inside the same transaction.
+func (s Store) PutBatch(_ context.Context, batch []blob.PutOptions) error {
+	return s.db.Update(func(tx *nutsdb.Tx) error {
+		seen := make(map[string]bool)
+		for _, opts := range batch {
+			if !opts.Replace && seen[opts.Key] {
+				return blob.KeyExists(opts.Key)
+			}
+			if err := s.putTx(tx, opts); err != nil {
+				return err
+			}
+			seen[opts.Key] = true
+		}
+		return nil
+	})
+}
+
+// DeleteBatch removes each of the given keys from the store inside a single
+// transaction, so that either all of them are removed or, if any key is not
+// found, none of them are. Keys are applied in order.
+func (s Store) DeleteBatch(_ context.Context, keys []string) error {
+	return s.db.Update(func(tx *nutsdb.Tx) error {
+		for _, key := range keys {
+			if err := s.deleteTx(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
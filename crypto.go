@@ -0,0 +1,76 @@
+// Copyright 2023 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutstore
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// seal encrypts data for storage, if a cipher is configured for s. The nonce
+// required by the cipher is generated at random and prepended to the
+// returned ciphertext. If s has no cipher, data are returned unmodified.
+func (s Store) seal(data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return data, nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("nutstore: generate nonce: %w", err)
+	}
+	return s.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// open reverses seal, recovering the plaintext stored in data. If s has no
+// cipher, data are returned unmodified.
+func (s Store) open(data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return data, nil
+	}
+	ns := s.aead.NonceSize()
+	if len(data) < ns {
+		return nil, errors.New("nutstore: stored value is shorter than a nonce")
+	}
+	nonce, ciphertext := data[:ns], data[ns:]
+	return s.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// plainSize reports the plaintext length of an encrypted value of n bytes,
+// given the overhead imposed by s's cipher. If s has no cipher, n is
+// returned unchanged.
+func (s Store) plainSize(n int) int {
+	if s.aead == nil {
+		return n
+	}
+	return n - s.aead.NonceSize() - s.aead.Overhead()
+}
+
+// storageKey returns the on-disk key that key is stored under. If s has a
+// KeyHasher configured, the stored key is the hashed form; otherwise the key
+// is stored as given.
+func (s Store) storageKey(key string) []byte {
+	if s.hashKey != nil {
+		return []byte(s.hashKey(key))
+	}
+	return []byte(key)
+}
+
+// Cipher is the interface required of a value supplied as Options.Cipher. It
+// is satisfied by the AEAD implementations in the standard crypto packages,
+// for example cipher.NewGCM.
+type Cipher = cipher.AEAD
@@ -0,0 +1,131 @@
+// Copyright 2023 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutstore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/xujiajun/nutsdb"
+)
+
+// IntegrityOptions controls the behavior of Store.CheckIntegrity.
+type IntegrityOptions struct {
+	// Repair, if true, causes CheckIntegrity to delete the entries that
+	// failed their check, in a single transaction, once the scan completes.
+	Repair bool
+}
+
+// badEntry records the physical location of an entry that failed its
+// integrity check, so Repair can delete it without re-deriving its bucket
+// or on-disk key from the logical key (which KeyHasher may have changed).
+type badEntry struct {
+	bucket string
+	key    []byte
+}
+
+// CheckIntegrity scans every entry actually stored on disk across all of s's
+// buckets — the same raw (bucket, key, value) records Backup copies — and
+// attempts to open each value, so a KeyHasher or Shards configuration on s
+// does not interfere with the scan the way routing a logical key back
+// through Get would. For each entry whose value fails to decode (for
+// example because its ciphertext has been corrupted) or, when
+// Options.HashFunc is configured, whose stored key does not match the
+// content hash of its (decoded) value, report is called with the stored key
+// and the error that was found. If report returns an error, CheckIntegrity
+// stops the scan and returns that error.
+//
+// The content-hash check is skipped when Options.KeyHasher is also
+// configured: the scan only ever sees the hashed on-disk key, never the
+// logical key the hash was computed from, so there is nothing meaningful to
+// compare HashFunc's output against. Decode failures are still reported
+// and repaired in that configuration; only the hash comparison is omitted.
+//
+// If opts.Repair is true, the entries that failed their check are deleted
+// from the store in a single transaction after the scan completes, and
+// CheckIntegrity returns the stored keys that were removed; otherwise it
+// returns nil. A nil opts is equivalent to &IntegrityOptions{}.
+func (s Store) CheckIntegrity(ctx context.Context, opts *IntegrityOptions, report func(key string, err error) error) ([]string, error) {
+	var bad []badEntry
+	err := s.db.View(func(tx *nutsdb.Tx) error {
+		for i := 0; i < s.numBuckets(); i++ {
+			bucket := s.bucket
+			if s.shards > 1 {
+				bucket = s.shardBucket(i)
+			}
+			it := nutsdb.NewIterator(tx, bucket, nutsdb.IteratorOptions{})
+			for {
+				ok, err := it.SetNext()
+				if isNutFound(err) || !ok {
+					break
+				} else if err != nil {
+					return err
+				} else if err := ctx.Err(); err != nil {
+					return err
+				}
+				e := it.Entry()
+				key := string(e.Key)
+				data, err := s.open(e.Value)
+				if err == nil {
+					err = s.checkHash(key, data)
+				}
+				if err == nil {
+					continue
+				}
+				bad = append(bad, badEntry{bucket: bucket, key: append([]byte{}, e.Key...)})
+				if err := report(key, err); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil || !opts.Repair || len(bad) == 0 {
+		return nil, nil
+	}
+	repaired := make([]string, len(bad))
+	err = s.db.Update(func(tx *nutsdb.Tx) error {
+		for i, e := range bad {
+			if err := tx.Delete(e.bucket, e.key); err != nil {
+				return err
+			}
+			repaired[i] = string(e.key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repaired, nil
+}
+
+// checkHash reports whether key is the content hash of data, when s has a
+// HashFunc configured and no KeyHasher (see CheckIntegrity); it reports nil
+// unconditionally otherwise.
+func (s Store) checkHash(key string, data []byte) error {
+	if s.newHash == nil || s.hashKey != nil {
+		return nil
+	}
+	h := s.newHash()
+	h.Write(data)
+	if got := hex.EncodeToString(h.Sum(nil)); got != key {
+		return fmt.Errorf("nutstore: content hash mismatch for key %q", key)
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ package nutstore
 import (
 	"context"
 	"errors"
+	"hash"
 	"strings"
 
 	"github.com/creachadair/ffs/blob"
@@ -26,8 +27,12 @@ import (
 
 // Store implements the blob.Store interface using a NutsDB instance.
 type Store struct {
-	db     *nutsdb.DB
-	bucket string
+	db      *nutsdb.DB
+	bucket  string
+	aead    Cipher
+	hashKey func(string) string
+	shards  int
+	newHash func() hash.Hash
 }
 
 // Opener constructs a store backed by NutsDB from an address comprising a
@@ -45,12 +50,46 @@ func Open(path string, opts *Options) (Store, error) {
 	if err != nil {
 		return Store{}, err
 	}
-	return Store{db: db, bucket: opts.bucket()}, nil
+	return Store{
+		db:      db,
+		bucket:  opts.bucket(),
+		aead:    opts.cipher(),
+		hashKey: opts.keyHasher(),
+		shards:  opts.shards(),
+		newHash: opts.hashFunc(),
+	}, nil
 }
 
 // Options provides options for opening a NutsDB instance.
 type Options struct {
 	Bucket string // use this bucket name
+
+	// Cipher, if set, is used to seal values written by Put and open values
+	// read by Get, so that blob contents are encrypted at rest; Size reports
+	// the plaintext length without opening the value. A
+	// random nonce is generated for each value and stored alongside the
+	// ciphertext. Keys are not affected by Cipher; use KeyHasher if key
+	// privacy is also required.
+	Cipher Cipher
+
+	// KeyHasher, if set, is applied to each key to derive the key actually
+	// stored in NutsDB, for example an HMAC keyed with a secret known only
+	// to the caller. This gives key privacy at rest, at the cost of making
+	// List report the hashed keys rather than the original ones.
+	KeyHasher func(key string) string
+
+	// Shards, if greater than 1, spreads keys across this many NutsDB
+	// buckets named "<Bucket>-0000" through "<Bucket>-NNNN", chosen by
+	// hashing each key. This works around NutsDB's per-bucket index and
+	// mmap overhead on large blob sets. Values of 0 or 1 disable sharding.
+	Shards int
+
+	// HashFunc, if set, constructs the hash used by CheckIntegrity to verify
+	// that a key is the content hash of its value, for content-addressed
+	// stores built on blob.HashCAS. This check is skipped when KeyHasher is
+	// also set, since CheckIntegrity then only ever observes the hashed
+	// on-disk key, not the logical key the content hash was computed from.
+	HashFunc func() hash.Hash
 }
 
 func (o *Options) bucket() string {
@@ -60,6 +99,34 @@ func (o *Options) bucket() string {
 	return o.Bucket
 }
 
+func (o *Options) cipher() Cipher {
+	if o == nil {
+		return nil
+	}
+	return o.Cipher
+}
+
+func (o *Options) keyHasher() func(string) string {
+	if o == nil {
+		return nil
+	}
+	return o.KeyHasher
+}
+
+func (o *Options) shards() int {
+	if o == nil {
+		return 0
+	}
+	return o.Shards
+}
+
+func (o *Options) hashFunc() func() hash.Hash {
+	if o == nil {
+		return nil
+	}
+	return o.HashFunc
+}
+
 // Close implements part of the blob.Store interface. It closes the underlying
 // database instance and reports its result.
 func (s Store) Close(_ context.Context) error {
@@ -74,7 +141,7 @@ func (s Store) Close(_ context.Context) error {
 func (s Store) Get(_ context.Context, key string) ([]byte, error) {
 	var data []byte
 	if err := s.db.View(func(tx *nutsdb.Tx) error {
-		e, err := tx.Get(s.bucket, []byte(key))
+		e, err := tx.Get(s.bucketName(key), s.storageKey(key))
 		if err == nil {
 			data = append([]byte{}, e.Value...)
 		}
@@ -84,31 +151,40 @@ func (s Store) Get(_ context.Context, key string) ([]byte, error) {
 	} else if err != nil {
 		return nil, err
 	}
-	return data, nil
+	return s.open(data)
 }
 
 // Put implements part of blob.Store.
 func (s Store) Put(_ context.Context, opts blob.PutOptions) error {
-	return s.db.Update(func(tx *nutsdb.Tx) error {
-		if !opts.Replace {
-			_, err := tx.Get(s.bucket, []byte(opts.Key))
-			if err == nil {
-				return blob.KeyExists(opts.Key)
-			} else if !isNutFound(err) {
-				return err
-			}
+	return s.db.Update(func(tx *nutsdb.Tx) error { return s.putTx(tx, opts) })
+}
+
+// putTx executes a single Put operation against an open transaction, so that
+// it can be shared between Put and PutBatch.
+func (s Store) putTx(tx *nutsdb.Tx, opts blob.PutOptions) error {
+	bucket, bk := s.bucketName(opts.Key), s.storageKey(opts.Key)
+	if !opts.Replace {
+		_, err := tx.Get(bucket, bk)
+		if err == nil {
+			return blob.KeyExists(opts.Key)
+		} else if !isNutFound(err) {
+			return err
 		}
-		return tx.Put(s.bucket, []byte(opts.Key), opts.Data, nutsdb.Persistent)
-	})
+	}
+	data, err := s.seal(opts.Data)
+	if err != nil {
+		return err
+	}
+	return tx.Put(bucket, bk, data, nutsdb.Persistent)
 }
 
 // Size implements part of blob.Store.
 func (s Store) Size(_ context.Context, key string) (int64, error) {
 	var size int64
 	if err := s.db.View(func(tx *nutsdb.Tx) error {
-		e, err := tx.Get(s.bucket, []byte(key))
+		e, err := tx.Get(s.bucketName(key), s.storageKey(key))
 		if err == nil {
-			size = int64(len(e.Value))
+			size = int64(s.plainSize(len(e.Value)))
 		}
 		return err
 	}); isNutFound(err) {
@@ -121,15 +197,19 @@ func (s Store) Size(_ context.Context, key string) (int64, error) {
 
 // Delete implements part of blob.Store.
 func (s Store) Delete(ctx context.Context, key string) error {
-	return s.db.Update(func(tx *nutsdb.Tx) error {
-		bk := []byte(key)
-		if _, err := tx.Get(s.bucket, bk); isNutFound(err) {
-			return blob.KeyNotFound(key)
-		} else if err != nil {
-			return err
-		}
-		return tx.Delete(s.bucket, bk)
-	})
+	return s.db.Update(func(tx *nutsdb.Tx) error { return s.deleteTx(tx, key) })
+}
+
+// deleteTx executes a single Delete operation against an open transaction,
+// so that it can be shared between Delete and DeleteBatch.
+func (s Store) deleteTx(tx *nutsdb.Tx, key string) error {
+	bucket, bk := s.bucketName(key), s.storageKey(key)
+	if _, err := tx.Get(bucket, bk); isNutFound(err) {
+		return blob.KeyNotFound(key)
+	} else if err != nil {
+		return err
+	}
+	return tx.Delete(bucket, bk)
 }
 
 func isNutFound(err error) bool {
@@ -138,6 +218,13 @@ func isNutFound(err error) bool {
 
 // List implements part of blob.Store.
 func (s Store) List(ctx context.Context, start string, f func(string) error) error {
+	if s.shards > 1 {
+		err := s.listSharded(ctx, start, f)
+		if errors.Is(err, blob.ErrStopListing) {
+			return nil
+		}
+		return err
+	}
 	err := s.db.View(func(tx *nutsdb.Tx) error {
 		it := nutsdb.NewIterator(tx, s.bucket, nutsdb.IteratorOptions{})
 		it.Seek([]byte(start))
@@ -0,0 +1,378 @@
+// Copyright 2023 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/nutstore"
+)
+
+func mustGCM(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher([]byte("0123456789abcdef0123456789abcdef"[:32]))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM: %v", err)
+	}
+	return gcm
+}
+
+// TestCipherRoundTrip verifies that Put, Get, and Size all agree on a blob's
+// plaintext and length when a Cipher is configured, and that the bytes
+// actually stored on disk do not contain the plaintext.
+func TestCipherRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := nutstore.Open(dir, &nutstore.Options{Bucket: "b", Cipher: mustGCM(t)})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close(ctx)
+
+	const key = "key"
+	want := []byte("the quick brown fox jumps over the lazy dog")
+	if err := s.Put(ctx, blob.PutOptions{Key: key, Data: want}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	} else if string(got) != string(want) {
+		t.Errorf("Get = %q, want %q", got, want)
+	}
+
+	size, err := s.Size(ctx, key)
+	if err != nil {
+		t.Fatalf("Size: %v", err)
+	} else if size != int64(len(want)) {
+		t.Errorf("Size = %d, want %d", size, len(want))
+	}
+
+	// Reopening without the cipher must not be able to read the plaintext
+	// back out of the sealed bytes stored on disk.
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	plain, err := nutstore.Open(dir, &nutstore.Options{Bucket: "b"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer plain.Close(ctx)
+	raw, err := plain.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get (no cipher): %v", err)
+	}
+	if strings.Contains(string(raw), string(want)) {
+		t.Errorf("sealed value contains plaintext: %q", raw)
+	}
+}
+
+// TestPutBatch verifies that PutBatch honors Replace exactly as Put does,
+// both against keys already in the store and between entries of the same
+// batch, and that a failing entry leaves none of the batch's writes visible.
+func TestPutBatch(t *testing.T) {
+	ctx := context.Background()
+	s, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close(ctx)
+
+	if err := s.Put(ctx, blob.PutOptions{Key: "old", Data: []byte("1")}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// A batch that collides with an existing key must fail entirely.
+	err = s.PutBatch(ctx, []blob.PutOptions{
+		{Key: "new", Data: []byte("2")},
+		{Key: "old", Data: []byte("3")},
+	})
+	if !blob.IsKeyExists(err) {
+		t.Errorf("PutBatch (existing collision) = %v, want KeyExists", err)
+	}
+	if _, err := s.Get(ctx, "new"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get(new) after failed batch: err = %v, want KeyNotFound", err)
+	}
+
+	// A batch with a duplicate key and Replace false must fail entirely, even
+	// though the earlier write in the same batch is not yet visible to Get.
+	err = s.PutBatch(ctx, []blob.PutOptions{
+		{Key: "dup", Data: []byte("1")},
+		{Key: "dup", Data: []byte("2")},
+	})
+	if !blob.IsKeyExists(err) {
+		t.Errorf("PutBatch (intra-batch collision) = %v, want KeyExists", err)
+	}
+	if _, err := s.Get(ctx, "dup"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get(dup) after failed batch: err = %v, want KeyNotFound", err)
+	}
+
+	// A duplicate key with Replace true on the second entry is fine, and the
+	// later write wins.
+	if err := s.PutBatch(ctx, []blob.PutOptions{
+		{Key: "dup", Data: []byte("1")},
+		{Key: "dup", Data: []byte("2"), Replace: true},
+	}); err != nil {
+		t.Fatalf("PutBatch (intra-batch replace): %v", err)
+	}
+	if got, err := s.Get(ctx, "dup"); err != nil {
+		t.Fatalf("Get(dup): %v", err)
+	} else if string(got) != "2" {
+		t.Errorf("Get(dup) = %q, want %q", got, "2")
+	}
+}
+
+// TestDeleteBatch verifies that DeleteBatch removes every key it is given,
+// and that a batch containing an unknown key fails and removes none of them.
+func TestDeleteBatch(t *testing.T) {
+	ctx := context.Background()
+	s, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close(ctx)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := s.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+
+	if err := s.DeleteBatch(ctx, []string{"a", "missing", "b"}); !blob.IsKeyNotFound(err) {
+		t.Errorf("DeleteBatch (missing key) = %v, want KeyNotFound", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := s.Get(ctx, key); err != nil {
+			t.Errorf("Get(%q) after failed DeleteBatch: %v, want no error", key, err)
+		}
+	}
+
+	if err := s.DeleteBatch(ctx, []string{"a", "b", "c"}); err != nil {
+		t.Fatalf("DeleteBatch: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := s.Get(ctx, key); !blob.IsKeyNotFound(err) {
+			t.Errorf("Get(%q) after DeleteBatch: err = %v, want KeyNotFound", key, err)
+		}
+	}
+}
+
+// TestListSharded_Sparse verifies that List and Len return every key once a
+// store is sharded even when some shard buckets have never been written to,
+// which used to either panic or silently report zero keys.
+func TestListSharded_Sparse(t *testing.T) {
+	ctx := context.Background()
+	s, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b", Shards: 8})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close(ctx)
+
+	want := []string{"k1", "k2", "k3"}
+	for _, key := range want {
+		if err := s.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+
+	for _, start := range []string{"", "k0"} {
+		var got []string
+		if err := s.List(ctx, start, func(key string) error {
+			got = append(got, key)
+			return nil
+		}); err != nil {
+			t.Fatalf("List(start=%q): %v", start, err)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("List(start=%q) = %v, want %v", start, got, want)
+		}
+	}
+
+	n, err := s.Len(ctx)
+	if err != nil {
+		t.Fatalf("Len: %v", err)
+	} else if n != int64(len(want)) {
+		t.Errorf("Len = %d, want %d", n, len(want))
+	}
+}
+
+// TestCheckIntegrity_KeyHasher verifies that CheckIntegrity does not
+// misreport healthy entries as corrupt when a KeyHasher is configured, which
+// used to happen because the scan re-hashed an already-hashed key.
+func TestCheckIntegrity_KeyHasher(t *testing.T) {
+	ctx := context.Background()
+	hashKey := func(key string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(key))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	s, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b", KeyHasher: hashKey})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close(ctx)
+
+	if err := s.Put(ctx, blob.PutOptions{Key: "hello", Data: []byte("world")}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	bad, err := s.CheckIntegrity(ctx, nil, func(key string, err error) error {
+		t.Errorf("unexpected integrity failure for %q: %v", key, err)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	} else if len(bad) != 0 {
+		t.Errorf("CheckIntegrity reported bad keys: %v", bad)
+	}
+}
+
+// TestCheckIntegrity_DecodeFailure verifies that CheckIntegrity detects and,
+// with Repair, removes an entry whose stored bytes are not a valid sealed
+// value for the configured Cipher, simulating on-disk corruption.
+func TestCheckIntegrity_DecodeFailure(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	plain, err := nutstore.Open(dir, &nutstore.Options{Bucket: "b"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := plain.Put(ctx, blob.PutOptions{Key: "broken", Data: []byte("short")}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := plain.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s, err := nutstore.Open(dir, &nutstore.Options{Bucket: "b", Cipher: mustGCM(t)})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer s.Close(ctx)
+
+	var reported []string
+	bad, err := s.CheckIntegrity(ctx, &nutstore.IntegrityOptions{Repair: true}, func(key string, _ error) error {
+		reported = append(reported, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+	if len(reported) != 1 || reported[0] != "broken" {
+		t.Errorf("reported = %v, want [broken]", reported)
+	}
+	if len(bad) != 1 || bad[0] != "broken" {
+		t.Errorf("repaired = %v, want [broken]", bad)
+	}
+
+	if _, err := s.Get(ctx, "broken"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get(broken) after repair: err = %v, want KeyNotFound", err)
+	}
+}
+
+// TestBackupRestore verifies that Restore on a fresh store reproduces every
+// key and value that Backup captured.
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	src, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b", Shards: 4})
+	if err != nil {
+		t.Fatalf("Open (src): %v", err)
+	}
+	defer src.Close(ctx)
+
+	want := map[string]string{"k1": "v1", "k2": "v2", "k3": "v3"}
+	for key, val := range want {
+		if err := src.Put(ctx, blob.PutOptions{Key: key, Data: []byte(val)}); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := src.Backup(ctx, &buf); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	dst, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b", Shards: 4})
+	if err != nil {
+		t.Fatalf("Open (dst): %v", err)
+	}
+	defer dst.Close(ctx)
+
+	if err := dst.Restore(ctx, &buf); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	for key, val := range want {
+		got, err := dst.Get(ctx, key)
+		if err != nil {
+			t.Errorf("Get(%q): %v", key, err)
+		} else if string(got) != val {
+			t.Errorf("Get(%q) = %q, want %q", key, got, val)
+		}
+	}
+}
+
+// TestRestore_Truncated verifies that Restore reports an error, rather than
+// silently discarding data, when the backup stream is cut off partway
+// through a record instead of ending cleanly between records.
+func TestRestore_Truncated(t *testing.T) {
+	ctx := context.Background()
+	src, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b"})
+	if err != nil {
+		t.Fatalf("Open (src): %v", err)
+	}
+	defer src.Close(ctx)
+	if err := src.Put(ctx, blob.PutOptions{Key: "key", Data: []byte("value")}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.Backup(ctx, &buf); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	truncated := buf.Bytes()[:buf.Len()-1]
+
+	dst, err := nutstore.Open(t.TempDir(), &nutstore.Options{Bucket: "b"})
+	if err != nil {
+		t.Fatalf("Open (dst): %v", err)
+	}
+	defer dst.Close(ctx)
+
+	err = dst.Restore(ctx, bytes.NewReader(truncated))
+	if err == nil {
+		t.Fatal("Restore(truncated) succeeded, want error")
+	}
+	if errors.Is(err, io.EOF) {
+		t.Errorf("Restore(truncated) = %v, want an error other than io.EOF", err)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2023 Michael J. Fromberger. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nutstore
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/xujiajun/nutsdb"
+)
+
+// Sub returns a Store bound to a sub-bucket of s named name, nested under
+// s's own bucket. The returned store shares s's underlying database, cipher,
+// key hasher, and shard count, so it behaves as an independent blob.Store
+// namespace within the same NutsDB instance.
+func (s Store) Sub(name string) blob.Store {
+	s.bucket = s.bucket + "/" + name
+	return s
+}
+
+// bucketName returns the name of the bucket that key is stored under. When s
+// is not sharded, this is simply s.bucket; otherwise the key is hashed into
+// one of s.shards buckets named "bucket-0000" through "bucket-NNNN".
+func (s Store) bucketName(key string) string {
+	if s.shards <= 1 {
+		return s.bucket
+	}
+	return s.shardBucket(s.shardIndex(key))
+}
+
+// shardIndex reports which of s.shards buckets key is assigned to.
+func (s Store) shardIndex(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(s.shards))
+}
+
+// shardBucket returns the name of the i'th shard bucket of s.
+func (s Store) shardBucket(i int) string {
+	return fmt.Sprintf("%s-%04d", s.bucket, i)
+}
+
+// numBuckets reports how many buckets s's keys are spread across.
+func (s Store) numBuckets() int {
+	if s.shards <= 1 {
+		return 1
+	}
+	return s.shards
+}
+
+// shardIterator pairs a bucket iterator with a flag recording whether it is
+// exhausted, for use in the List merge heap.
+type shardIterator struct {
+	it   *nutsdb.Iterator
+	done bool
+}
+
+// advance moves it to its next entry, recording whether iteration is done.
+// A bucket that does not exist yet (because no key has ever hashed to it)
+// is treated as empty rather than an error.
+func (si *shardIterator) advance() error {
+	ok, err := si.it.SetNext()
+	if isNutFound(err) {
+		si.done = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+	si.done = !ok
+	return nil
+}
+
+// key returns the current entry's key, valid only when !si.done.
+func (si *shardIterator) key() string { return string(si.it.Entry().Key) }
+
+// shardHeap is a container/heap.Interface over a set of shardIterators,
+// ordered by the lexicographic order of their current keys. Exhausted
+// iterators sort last and are skipped by the caller.
+type shardHeap []*shardIterator
+
+func (h shardHeap) Len() int { return len(h) }
+func (h shardHeap) Less(i, j int) bool {
+	if h[i].done != h[j].done {
+		return h[j].done // non-done sorts before done
+	}
+	if h[i].done {
+		return false // neither has a current entry to compare
+	}
+	return h[i].key() < h[j].key()
+}
+func (h shardHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *shardHeap) Push(x any)   { *h = append(*h, x.(*shardIterator)) }
+func (h *shardHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// bucketExists reports whether bucket has ever been written to, using a
+// Get probe rather than Iterator.Seek: Seek indexes into the bucket's
+// B+ tree directly and panics if the bucket was never created, whereas Get
+// only consults db.BPTreeIdx through a map lookup and reports
+// ErrBucketNotFound safely instead.
+func bucketExists(tx *nutsdb.Tx, bucket string) (bool, error) {
+	_, err := tx.Get(bucket, nil)
+	if err == nil || !nutsdb.IsBucketNotFound(err) {
+		return true, nil
+	}
+	return false, nil
+}
+
+// listSharded implements List across all of s's shard buckets, merging their
+// sorted iterators with a k-way heap so the combined output remains in
+// lexicographic order.
+func (s Store) listSharded(ctx context.Context, start string, f func(string) error) error {
+	return s.db.View(func(tx *nutsdb.Tx) error {
+		h := make(shardHeap, 0, s.numBuckets())
+		for i := 0; i < s.numBuckets(); i++ {
+			bucket := s.shardBucket(i)
+			it := nutsdb.NewIterator(tx, bucket, nutsdb.IteratorOptions{})
+			si := &shardIterator{it: it}
+			if start != "" {
+				// Seeking into a bucket that was never created panics (see
+				// bucketExists), so only seek once we know the bucket exists;
+				// an empty start relies on SetNext's own guarded lazy seek.
+				exists, err := bucketExists(tx, bucket)
+				if err != nil {
+					return err
+				} else if !exists {
+					si.done = true
+					h = append(h, si)
+					continue
+				}
+				if err := it.Seek([]byte(start)); err != nil {
+					return err
+				}
+			}
+			if err := si.advance(); err != nil {
+				return err
+			}
+			h = append(h, si)
+		}
+		heap.Init(&h)
+		for len(h) > 0 && !h[0].done {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			si := h[0]
+			if err := f(si.key()); err != nil {
+				return err
+			}
+			if err := si.advance(); err != nil {
+				return err
+			}
+			heap.Fix(&h, 0)
+		}
+		return nil
+	})
+}